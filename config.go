@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// InstanceConfig describes one watch-directory-to-Paperless-instance pairing.
+// A deployment can run several, each becoming its own Pipeline.
+type InstanceConfig struct {
+	Name               string `yaml:"name"`
+	WatchPath          string `yaml:"watch_path"`
+	PaperlessBaseURL   string `yaml:"paperless_base_url"`
+	PaperlessAuthToken string `yaml:"paperless_auth_token"`
+}
+
+// Config is the fully resolved configuration for a run of doc2paperless:
+// one or more instances, plus the tuning knobs shared across all of them.
+type Config struct {
+	Instances []InstanceConfig `yaml:"instances"`
+
+	Whitelist                  string
+	FileStabilityCheckInterval time.Duration
+	FileStabilityCheckCount    int
+	PacerMinSleep              time.Duration
+	PacerMaxSleep              time.Duration
+	PacerBackoffFactor         float64
+	PacerDecay                 float64
+	MaxRetries                 int
+	MaxConcurrentUploads       int
+	StateDir                   string
+	Verbose                    bool
+	ShutdownDrainTimeout       time.Duration
+}
+
+// configFile is the on-disk shape of $CONFIG_FILE, used only to describe
+// multiple instances; the shared tuning knobs are always read from the
+// environment, whether or not a config file is present.
+type configFile struct {
+	Instances []InstanceConfig `yaml:"instances"`
+}
+
+// LoadConfig builds a Config from $CONFIG_FILE if set, or else from a single
+// instance described by CONSUME_FOLDER/PAPERLESS_BASE_URL/PAPERLESS_AUTH_TOKEN,
+// matching the single-instance environment variables this service has always
+// supported.
+func LoadConfig() (*Config, error) {
+	instances, err := loadInstances()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{Instances: instances}
+
+	cfg.Whitelist = os.Getenv("FILE_CONSUME_WHITELIST")
+
+	cfg.FileStabilityCheckInterval, err = time.ParseDuration(os.Getenv("FILE_STABILITY_CHECK_INTERVAL_SECONDS"))
+	if err != nil {
+		cfg.FileStabilityCheckInterval = 2 * time.Second
+	}
+
+	cfg.FileStabilityCheckCount = 5
+	if count := os.Getenv("FILE_STABILITY_CHECK_COUNT"); count != "" {
+		fmt.Sscanf(count, "%d", &cfg.FileStabilityCheckCount)
+	}
+
+	cfg.PacerMinSleep, err = time.ParseDuration(os.Getenv("HTTP_UPLOAD_MIN_SLEEP"))
+	if err != nil {
+		cfg.PacerMinSleep = 10 * time.Millisecond
+	}
+
+	cfg.PacerMaxSleep, err = time.ParseDuration(os.Getenv("HTTP_UPLOAD_MAX_SLEEP"))
+	if err != nil {
+		cfg.PacerMaxSleep = 2 * time.Second
+	}
+
+	cfg.PacerBackoffFactor = 2
+	if factor := os.Getenv("HTTP_UPLOAD_BACKOFF_FACTOR"); factor != "" {
+		fmt.Sscanf(factor, "%f", &cfg.PacerBackoffFactor)
+	}
+
+	cfg.PacerDecay = 2
+	if decay := os.Getenv("HTTP_UPLOAD_DECAY"); decay != "" {
+		fmt.Sscanf(decay, "%f", &cfg.PacerDecay)
+	}
+
+	cfg.MaxRetries = 10
+	if retries := os.Getenv("HTTP_UPLOAD_MAX_RETRIES"); retries != "" {
+		fmt.Sscanf(retries, "%d", &cfg.MaxRetries)
+	}
+
+	cfg.MaxConcurrentUploads = runtime.GOMAXPROCS(0)
+	if limit := os.Getenv("MAX_CONCURRENT_UPLOADS"); limit != "" {
+		fmt.Sscanf(limit, "%d", &cfg.MaxConcurrentUploads)
+	}
+
+	cfg.StateDir = os.Getenv("STATE_DIR")
+
+	cfg.ShutdownDrainTimeout, err = time.ParseDuration(os.Getenv("SHUTDOWN_DRAIN_TIMEOUT"))
+	if err != nil {
+		cfg.ShutdownDrainTimeout = 30 * time.Second
+	}
+
+	cfg.Verbose = false
+	if verboseStr := os.Getenv("VERBOSE"); verboseStr != "" {
+		if parsed, err := strconv.ParseBool(verboseStr); err == nil {
+			cfg.Verbose = parsed
+		}
+	}
+
+	return cfg, nil
+}
+
+// loadInstances reads $CONFIG_FILE's "instances" list when set, otherwise
+// falls back to the single instance described by the legacy environment
+// variables, named "default".
+func loadInstances() ([]InstanceConfig, error) {
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading config file %s: %w", path, err)
+		}
+
+		var parsed configFile
+		if err := yaml.Unmarshal(data, &parsed); err != nil {
+			return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+		}
+		if len(parsed.Instances) == 0 {
+			return nil, fmt.Errorf("config file %s defines no instances", path)
+		}
+		for i, inst := range parsed.Instances {
+			if inst.Name == "" || inst.WatchPath == "" || inst.PaperlessBaseURL == "" || inst.PaperlessAuthToken == "" {
+				return nil, fmt.Errorf("instance %d in %s is missing a required field (name, watch_path, paperless_base_url, paperless_auth_token)", i, path)
+			}
+		}
+		return parsed.Instances, nil
+	}
+
+	watchPath := os.Getenv("CONSUME_FOLDER")
+	baseURL := os.Getenv("PAPERLESS_BASE_URL")
+	token := os.Getenv("PAPERLESS_AUTH_TOKEN")
+
+	if baseURL == "" || watchPath == "" {
+		return nil, fmt.Errorf("missing required environment variables: PAPERLESS_BASE_URL, CONSUME_FOLDER")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("environment variable PAPERLESS_AUTH_TOKEN not set. Note: currently only auth tokens are supported, not Base64(user:pass)")
+	}
+
+	return []InstanceConfig{{
+		Name:               "default",
+		WatchPath:          watchPath,
+		PaperlessBaseURL:   baseURL,
+		PaperlessAuthToken: token,
+	}}, nil
+}
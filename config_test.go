@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadInstancesParsesValidMultiInstanceFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	writeFile(t, path, `
+instances:
+  - name: office
+    watch_path: /watch/office
+    paperless_base_url: http://office.local
+    paperless_auth_token: office-token
+  - name: home
+    watch_path: /watch/home
+    paperless_base_url: http://home.local
+    paperless_auth_token: home-token
+`)
+	t.Setenv("CONFIG_FILE", path)
+
+	instances, err := loadInstances()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(instances) != 2 {
+		t.Fatalf("expected 2 instances, got %d", len(instances))
+	}
+	if instances[0].Name != "office" || instances[1].Name != "home" {
+		t.Errorf("expected instances named office and home, got %+v", instances)
+	}
+}
+
+func TestLoadInstancesRejectsMissingRequiredField(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	writeFile(t, path, `
+instances:
+  - name: office
+    watch_path: /watch/office
+    paperless_base_url: http://office.local
+`)
+	t.Setenv("CONFIG_FILE", path)
+
+	if _, err := loadInstances(); err == nil {
+		t.Errorf("expected an error for an instance missing paperless_auth_token")
+	}
+}
+
+func TestLoadInstancesFallsBackToEnvironmentWhenConfigFileUnset(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("CONSUME_FOLDER", "/watch/default")
+	t.Setenv("PAPERLESS_BASE_URL", "http://paperless.local")
+	t.Setenv("PAPERLESS_AUTH_TOKEN", "token123")
+
+	instances, err := loadInstances()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(instances) != 1 {
+		t.Fatalf("expected a single fallback instance, got %d", len(instances))
+	}
+	if instances[0].Name != "default" || instances[0].WatchPath != "/watch/default" {
+		t.Errorf("expected the legacy environment variables to populate a \"default\" instance, got %+v", instances[0])
+	}
+}
+
+// writeFile writes contents to path, failing the test on error.
+func writeFile(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatalf("expected no error writing %s, got %v", path, err)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	paperlessReachabilityCacheTTL = 10 * time.Second
+	watcherStaleAfter             = 5 * time.Minute
+	channelBackpressureThreshold  = 10
+	healthMonitorInterval         = 15 * time.Second
+)
+
+// HealthCheck reports whether a named subsystem is healthy, with a short
+// human-readable detail (always set when unhealthy; a healthy check may
+// leave it empty or report context such as channel depths).
+type HealthCheck func() (healthy bool, detail string)
+
+// watcherHealth tracks whether a Pipeline's fsnotify watcher goroutine is
+// alive and when it last saw a file event, backing its watcher_alive and
+// watcher_event_age checks.
+type watcherHealth struct {
+	mu        sync.Mutex
+	alive     bool
+	lastEvent time.Time
+}
+
+func (w *watcherHealth) markAlive() {
+	w.mu.Lock()
+	w.alive = true
+	w.mu.Unlock()
+}
+
+func (w *watcherHealth) markDead() {
+	w.mu.Lock()
+	w.alive = false
+	w.mu.Unlock()
+}
+
+func (w *watcherHealth) markEvent() {
+	w.mu.Lock()
+	w.alive = true
+	w.lastEvent = time.Now()
+	w.mu.Unlock()
+}
+
+func (w *watcherHealth) snapshot() (alive bool, lastEvent time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.alive, w.lastEvent
+}
+
+// paperlessReachability caches the result of probing a Paperless instance's
+// API so readiness and the "paperless" health check don't hit the server on
+// every poll.
+type paperlessReachability struct {
+	client  HTTPClient
+	baseURL string
+	token   string
+	ttl     time.Duration
+
+	mu       sync.Mutex
+	expires  time.Time
+	lastKind string
+	lastErr  error
+}
+
+func newPaperlessReachability(client HTTPClient, baseURL, token string, ttl time.Duration) *paperlessReachability {
+	return &paperlessReachability{client: client, baseURL: baseURL, token: token, ttl: ttl}
+}
+
+// Check returns the cached probe result, refreshing it if the cache has
+// expired.
+func (p *paperlessReachability) Check() (healthy bool, kind string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if time.Now().Before(p.expires) {
+		return p.lastErr == nil, p.lastKind, p.lastErr
+	}
+
+	kind, err = p.probe()
+	p.lastKind = kind
+	p.lastErr = err
+	p.expires = time.Now().Add(p.ttl)
+
+	return err == nil, kind, err
+}
+
+func (p *paperlessReachability) probe() (string, error) {
+	u := strings.TrimSuffix(p.baseURL, "/") + "/api/ui_settings/"
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return "request_error", err
+	}
+	req.Header.Set("Authorization", "Token "+p.token)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "unreachable", err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return "unauthorized", fmt.Errorf("paperless rejected the configured token (401)")
+	case resp.StatusCode >= 500:
+		return "server_error", fmt.Errorf("paperless returned status %d", resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		return "unexpected_status", fmt.Errorf("paperless returned unexpected status %d", resp.StatusCode)
+	}
+
+	return "", nil
+}
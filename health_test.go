@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPaperlessReachabilityCachesResult(t *testing.T) {
+	calls := 0
+	client := &countingHTTPClient{
+		do: func(req *http.Request) (*http.Response, error) {
+			calls++
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+		},
+	}
+
+	check := newPaperlessReachability(client, "http://paperless.local", "token123", time.Minute)
+
+	for i := 0; i < 3; i++ {
+		ok, _, err := check.Check()
+		if !ok || err != nil {
+			t.Fatalf("expected a healthy cached result, got ok=%v err=%v", ok, err)
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("expected the probe to run once and then be cached, got %d calls", calls)
+	}
+}
+
+func TestPaperlessReachabilityReportsUnauthorized(t *testing.T) {
+	client := &countingHTTPClient{
+		do: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusUnauthorized, Body: io.NopCloser(strings.NewReader(""))}, nil
+		},
+	}
+
+	check := newPaperlessReachability(client, "http://paperless.local", "token123", time.Minute)
+
+	ok, kind, err := check.Check()
+	if ok {
+		t.Fatalf("expected a 401 to be reported as unhealthy")
+	}
+	if kind != "unauthorized" {
+		t.Errorf("expected kind unauthorized, got %s", kind)
+	}
+	if err == nil {
+		t.Errorf("expected a non-nil error")
+	}
+}
+
+func TestHealthHandlerReportsRegisteredChecks(t *testing.T) {
+	p := newTestPipeline(t, &MockFileSystem{}, &MockHTTPClient{})
+
+	p.healthChecksMu.Lock()
+	p.healthChecks = map[string]HealthCheck{}
+	p.healthChecksMu.Unlock()
+
+	p.RegisterHealthCheck("always_ok", func() (bool, string) { return true, "" })
+	p.RegisterHealthCheck("always_failing", func() (bool, string) { return false, "boom" })
+
+	pipelinesMu.Lock()
+	pipelines = []*Pipeline{p}
+	pipelinesMu.Unlock()
+	defer func() {
+		pipelinesMu.Lock()
+		pipelines = nil
+		pipelinesMu.Unlock()
+	}()
+
+	req := httptest.NewRequest(http.MethodGet, "/health/health", nil)
+	w := httptest.NewRecorder()
+	healthHandler(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 when a check fails, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "always_failing") {
+		t.Errorf("expected the failing check to be named in the response, got %s", w.Body.String())
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	ledgerStatusPending = "pending"
+	ledgerStatusSuccess = "success"
+	ledgerStatusFailed  = "failed"
+
+	taskPollInterval = 500 * time.Millisecond
+	taskPollTimeout  = 30 * time.Second
+)
+
+// ledgerEntry is one append-only record of an upload attempt, keyed by the
+// content hash of the document so duplicate uploads are recognized by
+// content rather than by name or path, even across restarts.
+type ledgerEntry struct {
+	Hash       string    `json:"hash"`
+	Path       string    `json:"path"`
+	UploadedAt time.Time `json:"uploaded_at"`
+	TaskID     string    `json:"task_id,omitempty"`
+	Status     string    `json:"status"`
+}
+
+// Ledger is a crash-safe, content-addressed record of every upload attempt,
+// appended to a JSON-lines file under $STATE_DIR (or, when that is unset, a
+// state directory inside the watch path). It lets uploadFile skip a
+// POST for content already accepted by Paperless, and lets a restart tell
+// which in-flight uploads need to be resumed.
+type Ledger struct {
+	mu      sync.Mutex
+	file    *os.File
+	entries map[string]ledgerEntry // keyed by hash
+}
+
+// OpenLedger loads path (creating it and its parent directory if absent)
+// and replays it into memory.
+func OpenLedger(path string) (*Ledger, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	l := &Ledger{entries: map[string]ledgerEntry{}}
+
+	if existing, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(existing)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var entry ledgerEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				continue
+			}
+			l.entries[entry.Hash] = entry
+		}
+		err = scanner.Err()
+		existing.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	l.file = file
+
+	return l, nil
+}
+
+func (l *Ledger) Close() error {
+	return l.file.Close()
+}
+
+// Lookup returns the last known entry for hash, if any.
+func (l *Ledger) Lookup(hash string) (ledgerEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry, ok := l.entries[hash]
+	return entry, ok
+}
+
+// Record appends entry to the log and updates the in-memory index.
+func (l *Ledger) Record(entry ledgerEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(encoded, '\n')); err != nil {
+		return err
+	}
+	l.entries[entry.Hash] = entry
+	return nil
+}
+
+// hashFile streams path's content through SHA-256, the same fs.Open path
+// uploadFile uses to read the document for the multipart body.
+func hashFile(fs FileSystem, path string) (string, error) {
+	reader, err := fs.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, reader); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLedgerRecordAndLookup(t *testing.T) {
+	dir := t.TempDir()
+	l, err := OpenLedger(filepath.Join(dir, "ledger.jsonl"))
+	if err != nil {
+		t.Fatalf("expected no error opening ledger, got %v", err)
+	}
+	defer l.Close()
+
+	if err := l.Record(ledgerEntry{Hash: "abc", Path: "/a.pdf", UploadedAt: time.Now(), Status: ledgerStatusSuccess}); err != nil {
+		t.Fatalf("expected no error recording entry, got %v", err)
+	}
+
+	entry, ok := l.Lookup("abc")
+	if !ok {
+		t.Fatalf("expected to find entry for hash abc")
+	}
+	if entry.Status != ledgerStatusSuccess {
+		t.Errorf("expected status success, got %s", entry.Status)
+	}
+}
+
+func TestLedgerSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ledger.jsonl")
+
+	l, err := OpenLedger(path)
+	if err != nil {
+		t.Fatalf("expected no error opening ledger, got %v", err)
+	}
+	l.Record(ledgerEntry{Hash: "abc", Path: "/a.pdf", UploadedAt: time.Now(), Status: ledgerStatusSuccess})
+	l.Close()
+
+	reopened, err := OpenLedger(path)
+	if err != nil {
+		t.Fatalf("expected no error reopening ledger, got %v", err)
+	}
+	defer reopened.Close()
+
+	entry, ok := reopened.Lookup("abc")
+	if !ok || entry.Status != ledgerStatusSuccess {
+		t.Errorf("expected replayed entry for hash abc with status success, got %+v (found=%v)", entry, ok)
+	}
+}
+
+func TestReconcileLedgerRemovesSuccessfulDuplicate(t *testing.T) {
+	fs := &MockFileSystem{Files: map[string]*MockFile{}}
+	p := newTestPipeline(t, fs, &MockHTTPClient{})
+
+	// newTestPipeline gives the pipeline its own disposable WatchPath, so the
+	// mock file has to live under that path rather than a hardcoded one.
+	path := filepath.Join(p.inst.WatchPath, "dup.pdf")
+	fs.Files[path] = &MockFile{FileName: "dup.pdf", Content: []byte("already uploaded")}
+
+	hash, err := hashFile(fs, path)
+	if err != nil {
+		t.Fatalf("expected no error hashing file, got %v", err)
+	}
+	p.ledger.Record(ledgerEntry{Hash: hash, Path: path, UploadedAt: time.Now(), Status: ledgerStatusSuccess})
+
+	if err := p.reconcileLedger(); err != nil {
+		t.Fatalf("expected no error reconciling ledger, got %v", err)
+	}
+
+	if _, exists := fs.Files[path]; exists {
+		t.Errorf("expected duplicate file to be removed during reconciliation")
+	}
+}
+
+func TestReconcileLedgerRemovesSidecarForSuccessfulDuplicate(t *testing.T) {
+	fs := &MockFileSystem{Files: map[string]*MockFile{}}
+	p := newTestPipeline(t, fs, &MockHTTPClient{})
+
+	path := filepath.Join(p.inst.WatchPath, "dup.pdf")
+	sidecarPath := path + ".json"
+	fs.Files[path] = &MockFile{FileName: "dup.pdf", Content: []byte("already uploaded")}
+	fs.Files[sidecarPath] = &MockFile{FileName: "dup.pdf.json", Content: []byte("{}")}
+
+	hash, err := hashFile(fs, path)
+	if err != nil {
+		t.Fatalf("expected no error hashing file, got %v", err)
+	}
+	p.ledger.Record(ledgerEntry{Hash: hash, Path: path, UploadedAt: time.Now(), Status: ledgerStatusSuccess})
+
+	if err := p.reconcileLedger(); err != nil {
+		t.Fatalf("expected no error reconciling ledger, got %v", err)
+	}
+
+	if _, exists := fs.Files[sidecarPath]; exists {
+		t.Errorf("expected the duplicate's sidecar to be removed alongside the document during reconciliation")
+	}
+}
@@ -1,39 +1,28 @@
 package main
 
 import (
-	"bytes"
-	"errors"
-	"fmt"
+	"context"
+	"encoding/json"
 	"io"
 	"log"
-	"mime/multipart"
 	"net/http"
 	"os"
-	"path/filepath"
-	"strconv"
-	"strings"
-	"time"
+	"os/signal"
+	"sync"
+	"syscall"
 
-	"github.com/fsnotify/fsnotify"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+var version = "dev"
+
+// pipelines is the set of running Pipelines, set once in main and read by
+// the shared HTTP handlers below to aggregate readiness/health across every
+// configured instance.
 var (
-	readyForUpload             = make(chan string)
-	fileStabilityConfirmation  = make(chan string)
-	successfulUploads          = prometheus.NewCounter(prometheus.CounterOpts{Name: "successful_uploads", Help: "Number of successful uploads"})
-	failedUploads              = prometheus.NewCounter(prometheus.CounterOpts{Name: "failed_uploads", Help: "Number of failed uploads"})
-	uploadRetries              = prometheus.NewCounter(prometheus.CounterOpts{Name: "upload_retries", Help: "Number of upload retries"})
-	paperlessBaseURL           string
-	paperlessAuthToken         string
-	watchPath                  string
-	fileStabilityCheckInterval time.Duration
-	fileStabilityCheckCount    int
-	retryDelay                 time.Duration
-	version                    = "dev"
-	whitelist                  string
-	verbose                    bool
+	pipelinesMu sync.RWMutex
+	pipelines   []*Pipeline
 )
 
 type FileSystem interface {
@@ -41,6 +30,7 @@ type FileSystem interface {
 	ReadDir(dirname string) ([]os.DirEntry, error)
 	Stat(name string) (os.FileInfo, error)
 	Remove(name string) error
+	Rename(oldpath, newpath string) error
 }
 
 type HTTPClient interface {
@@ -65,91 +55,83 @@ func (RealFileSystem) Remove(name string) error {
 	return os.Remove(name)
 }
 
+func (RealFileSystem) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
 type RealHTTPClient struct{}
 
 func (RealHTTPClient) Do(req *http.Request) (*http.Response, error) {
 	return http.DefaultClient.Do(req)
 }
 
-func init() {
-	prometheus.MustRegister(successfulUploads, failedUploads, uploadRetries)
-
-	os.Setenv("CONSUME_FOLDER", "c:/temp")
-	os.Setenv("FILE_CONSUME_WHITELIST", "*.pdf")
-	os.Setenv("HTTP_UPLOAD_RETRY_DELAY_SECONDS", "5s")
-	os.Setenv("FILE_STABILITY_CHECK_COUNT", "3")
-	os.Setenv("FILE_STABILITY_CHECK_INTERVAL_SECONDS", "2s")
-	//os.Setenv("PAPERLESS_AUTH_TOKEN", "57d6be2cd6968cf189dafcb989d4610d6274b923")
-	//os.Setenv("PAPERLESS_BASE_URL", "http://192.168.2.147:8000")
-	//os.Setenv("VERBOSE", "true")
-}
-
 func main() {
 	log.Println("Starting doc2paperless Version: " + version)
 
-	loadConfig()
+	cfg, err := LoadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	if verbose {
+	if cfg.Verbose {
 		log.Println("Verbose logging is enabled.")
 	}
 
-	http.Handle("/metrics", promhttp.Handler())
-	http.HandleFunc("/health/liveness", livenessHandler)
-	http.HandleFunc("/health/readiness", readinessHandler)
-
-	go func() {
-		log.Fatal(http.ListenAndServe(":2112", nil))
-	}()
-
 	fs := RealFileSystem{}
 	client := RealHTTPClient{}
 
-	go watchFiles(fs)
+	built := make([]*Pipeline, 0, len(cfg.Instances))
+	for _, inst := range cfg.Instances {
+		p, err := NewPipeline(inst, cfg, fs, client)
+		if err != nil {
+			log.Fatalf("failed to build pipeline %q: %v", inst.Name, err)
+		}
+		built = append(built, p)
+	}
 
-	go checkFileStability(fs)
+	pipelinesMu.Lock()
+	pipelines = built
+	pipelinesMu.Unlock()
 
-	uploadFiles(fs, client)
+	http.HandleFunc("/metrics", metricsHandler)
+	http.HandleFunc("/health/liveness", livenessHandler)
+	http.HandleFunc("/health/readiness", readinessHandler)
+	http.HandleFunc("/health/health", healthHandler)
 
-	select {} // Block forever
-}
+	go func() {
+		log.Fatal(http.ListenAndServe(":2112", nil))
+	}()
 
-func loadConfig() {
-	var err error
-	whitelist = os.Getenv("FILE_CONSUME_WHITELIST")
-	paperlessBaseURL = os.Getenv("PAPERLESS_BASE_URL")
-	paperlessAuthToken = os.Getenv("PAPERLESS_AUTH_TOKEN")
-	watchPath = os.Getenv("CONSUME_FOLDER")
-	if paperlessBaseURL == "" || watchPath == "" {
-		log.Fatal("Missing required environment variables: PAPERLESS_BASE_URL, CONSUME_FOLDER")
-	}
-	if paperlessAuthToken == "" {
-		log.Fatal("Environment Variable PAPERLESS_AUTH_TOKEN not set. Note: Currently only Auth token are supported, not Base64(user:pass)")
-	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	fileStabilityCheckInterval, err = time.ParseDuration(os.Getenv("FILE_STABILITY_CHECK_INTERVAL_SECONDS"))
-	if err != nil {
-		fileStabilityCheckInterval = 2 * time.Second
+	var wg sync.WaitGroup
+	for _, p := range built {
+		wg.Add(1)
+		go func(p *Pipeline) {
+			defer wg.Done()
+			if err := p.Run(ctx); err != nil {
+				log.Printf("[%s] pipeline exited with error: %v", p.name, err)
+			}
+		}(p)
 	}
 
-	fileStabilityCheckCount = 5
-	if count := os.Getenv("FILE_STABILITY_CHECK_COUNT"); count != "" {
-		fmt.Sscanf(count, "%d", &fileStabilityCheckCount)
-	}
+	wg.Wait()
+	log.Println("All pipelines stopped, exiting.")
+}
 
-	retryDelay, err = time.ParseDuration(os.Getenv("HTTP_UPLOAD_RETRY_DELAY_SECONDS"))
-	if err != nil {
-		retryDelay = 5 * time.Second
+// metricsHandler gathers every pipeline's own registry into one combined
+// response, since each Pipeline owns a private prometheus.Registry rather
+// than registering into the global default.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	pipelinesMu.RLock()
+	gatherers := make(prometheus.Gatherers, 0, len(pipelines))
+	for _, p := range pipelines {
+		gatherers = append(gatherers, p.registry)
 	}
+	pipelinesMu.RUnlock()
 
-	verboseStr := os.Getenv("VERBOSE")
-	verbose = false
-
-	if verboseStr != "" {
-		parsedVerbose, err := strconv.ParseBool(verboseStr)
-		if err == nil {
-			verbose = parsedVerbose
-		}
-	}
+	promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}).ServeHTTP(w, r)
 }
 
 func livenessHandler(w http.ResponseWriter, r *http.Request) {
@@ -157,174 +139,48 @@ func livenessHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
 
+// readinessHandler reports unhealthy as soon as any configured instance
+// can't reach its Paperless API, since a single unreachable instance means
+// this process cannot do its job for that instance's watch path.
 func readinessHandler(w http.ResponseWriter, r *http.Request) {
-	// Implement a real readiness check if needed
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
-}
-
-func watchFiles(fs FileSystem) {
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer watcher.Close()
+	pipelinesMu.RLock()
+	defer pipelinesMu.RUnlock()
 
-	err = watcher.Add(watchPath)
-	if err != nil {
-		log.Fatal(err)
-	}
+	w.Header().Set("Content-Type", "application/json")
 
-	// Check existing files at startup
-	files, err := os.ReadDir(watchPath)
-	if err != nil {
-		log.Fatal(err)
-	}
-	for _, file := range files {
-		if !file.IsDir() && isWhitelisted(file.Name()) {
-			fileStabilityConfirmation <- filepath.Join(watchPath, file.Name())
+	for _, p := range pipelines {
+		if ok, kind, err := p.paperlessCheck.Check(); !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]string{"status": "unhealthy", "instance": p.name, "reason": kind, "detail": err.Error()})
+			return
 		}
 	}
 
-	for {
-		select {
-		case event, ok := <-watcher.Events:
-			if !ok {
-				return
-			}
-			if event.Op&fsnotify.Create == fsnotify.Create && isWhitelisted(event.Name) {
-				log.Println("Detected new file. Starting stability check for: " + event.Name)
-				fileStabilityConfirmation <- event.Name
-			}
-		case err, ok := <-watcher.Errors:
-			if !ok {
-				return
-			}
-			log.Println("error:", err)
-		}
-	}
-}
-
-func checkFileStability(fs FileSystem) {
-	for filePath := range fileStabilityConfirmation {
-		go func(filePath string) {
-			var lastSize int64
-			consecutiveStableCount := 0
-
-			for {
-				if verbose {
-					log.Println("Checking stability for " + filePath + " Consecutive readings with same size: " + strconv.Itoa(consecutiveStableCount) + "/" + strconv.Itoa(fileStabilityCheckCount))
-				}
-
-				fileInfo, err := fs.Stat(filePath)
-				if err != nil {
-					log.Println("error:", err)
-					return
-				}
-
-				currentSize := fileInfo.Size()
-				if currentSize == lastSize {
-					consecutiveStableCount++
-					if consecutiveStableCount >= fileStabilityCheckCount {
-						if verbose {
-							log.Println(fmt.Sprintf("Checking stability for %s: Consecutive readings with same size: %d/%d -> OK, ready for Upload.", filePath, consecutiveStableCount, fileStabilityCheckCount))
-						}
-						readyForUpload <- filePath
-						return
-					}
-				} else {
-					consecutiveStableCount = 0
-				}
-
-				lastSize = currentSize
-				time.Sleep(fileStabilityCheckInterval)
-			}
-		}(filePath)
-	}
-}
-
-func uploadFiles(fs FileSystem, client HTTPClient) {
-	for filePath := range readyForUpload {
-		go func(filePath string) {
-			for {
-				err := uploadFile(fs, client, filePath)
-				if err == nil {
-					successfulUploads.Inc()
-					log.Printf("Successfully uploaded: %s\n", filePath)
-					fs.Remove(filePath)
-					break
-				}
-				failedUploads.Inc()
-				log.Printf("Failed to upload: %s, retrying...\n", filePath)
-				time.Sleep(retryDelay)
-			}
-		}(filePath)
-	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
 
-func uploadFile(fs FileSystem, client HTTPClient, filePath string) error {
-	fileReader, err := fs.Open(filePath)
-	if err != nil {
-		return err
-	}
-	defer fileReader.Close()
-
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-
-	part, err := writer.CreateFormFile("document", filepath.Base(filePath))
-	if err != nil {
-		return err
-	}
-
-	_, err = io.Copy(part, fileReader)
-	if err != nil {
-		return err
-	}
-
-	title := filepath.Base(filePath)
-	err = writer.WriteField("title", title)
-	if err != nil {
-		return err
-	}
-
-	err = writer.Close()
-	if err != nil {
-		return err
-	}
+// healthHandler aggregates every pipeline's registered checks into one JSON
+// response, keyed by instance name.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	pipelinesMu.RLock()
+	defer pipelinesMu.RUnlock()
 
-	url := strings.TrimSuffix(paperlessBaseURL, "/") + "/api/documents/post_document/"
-	req, err := http.NewRequest("POST", url, body)
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Header.Set("Authorization", "Token "+paperlessAuthToken)
-
-	resp, err := client.Do(req)
-	if err != nil {
-		uploadRetries.Inc()
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		uploadRetries.Inc()
-		responseBody, _ := io.ReadAll(resp.Body)
-		log.Printf("Failed to upload document: Status %d, Response: %s", resp.StatusCode, string(responseBody))
-		return errors.New("failed to upload document")
+	allHealthy := true
+	instances := make(map[string]map[string]map[string]interface{}, len(pipelines))
+	for _, p := range pipelines {
+		healthy, checks := p.runHealthChecks()
+		if !healthy {
+			allHealthy = false
+		}
+		instances[p.name] = checks
 	}
 
-	return nil
-}
-
-func isWhitelisted(filename string) bool {
-	ext := strings.ToLower(filepath.Ext(filename))
-	whitelistedExtensions := strings.Split(strings.ToLower(whitelist), ",")
-	for _, pattern := range whitelistedExtensions {
-		if matched, _ := filepath.Match(pattern, ext); matched {
-			return true
-		}
+	w.Header().Set("Content-Type", "application/json")
+	if !allHealthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
 	}
-	return false
+	json.NewEncoder(w).Encode(map[string]interface{}{"instances": instances})
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"net/http"
 	"os"
@@ -63,6 +64,16 @@ func (mfs *MockFileSystem) Remove(name string) error {
 	return os.ErrNotExist
 }
 
+func (mfs *MockFileSystem) Rename(oldpath, newpath string) error {
+	file, exists := mfs.Files[oldpath]
+	if !exists {
+		return os.ErrNotExist
+	}
+	delete(mfs.Files, oldpath)
+	mfs.Files[newpath] = file
+	return nil
+}
+
 // MockHTTPClient simulates an HTTP client for testing purposes.
 type MockHTTPClient struct {
 	Response *http.Response
@@ -73,6 +84,41 @@ func (m *MockHTTPClient) Do(req *http.Request) (*http.Response, error) {
 	return m.Response, m.Error
 }
 
+// newTestPipeline builds a Pipeline wired up with fs and client, its own
+// disposable ledger and state dir under t.TempDir(), and the whitelist and
+// stability settings most tests rely on.
+func newTestPipeline(t *testing.T, fs FileSystem, client HTTPClient) *Pipeline {
+	t.Helper()
+
+	cfg := &Config{
+		Whitelist:                  "*.pdf",
+		FileStabilityCheckInterval: time.Millisecond,
+		FileStabilityCheckCount:    3,
+		PacerMinSleep:              time.Millisecond,
+		PacerMaxSleep:              10 * time.Millisecond,
+		PacerBackoffFactor:         2,
+		PacerDecay:                 2,
+		MaxRetries:                 10,
+		StateDir:                   t.TempDir(),
+	}
+	inst := InstanceConfig{
+		Name: "test",
+		// NewPipeline creates this directory for real (for quarantined
+		// uploads), so it must be a real, disposable path rather than the
+		// "/consumefolder/..." paths MockFileSystem's Files map uses.
+		WatchPath:          t.TempDir(),
+		PaperlessBaseURL:   "http://paperless.local",
+		PaperlessAuthToken: "token123",
+	}
+
+	p, err := NewPipeline(inst, cfg, fs, client)
+	if err != nil {
+		t.Fatalf("expected no error building test pipeline, got %v", err)
+	}
+	t.Cleanup(func() { p.ledger.Close() })
+	return p
+}
+
 func TestUploadFile(t *testing.T) {
 	fs := &MockFileSystem{
 		Files: map[string]*MockFile{
@@ -80,24 +126,30 @@ func TestUploadFile(t *testing.T) {
 		},
 	}
 
-	client := &MockHTTPClient{
-		Response: &http.Response{
-			StatusCode: http.StatusOK,
-			Body:       io.NopCloser(strings.NewReader("")),
+	// The document POST and the task-status poll share a client, so the mock
+	// must tell them apart and resolve the poll, rather than replaying the
+	// same response, which pollTaskStatus cannot decode, so uploadFile would
+	// report a retryable error.
+	client := &countingHTTPClient{
+		do: func(req *http.Request) (*http.Response, error) {
+			if req.Method == http.MethodGet {
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`[{"status":"SUCCESS"}]`))}, nil
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`"42"`))}, nil
 		},
 	}
 
-	err := uploadFile(fs, client, "/consumefolder/test.pdf")
-	if err != nil {
-		t.Errorf("expected no error, got %v", err)
+	p := newTestPipeline(t, fs, client)
+	outcome := p.uploadFile("/consumefolder/test.pdf")
+	if outcome.err != nil {
+		t.Errorf("expected no error, got %v", outcome.err)
 	}
-
 }
 
 func TestUploadFileFailure(t *testing.T) {
 	fs := &MockFileSystem{
 		Files: map[string]*MockFile{
-			"/consumefolder/test.pdf": {FileName: "test.pdf", Content: []byte("test content"), FileSize: 12},
+			"/consumefolder/test.pdf": {FileName: "test.pdf", Content: []byte("test content that fails"), FileSize: 23},
 		},
 	}
 
@@ -108,10 +160,14 @@ func TestUploadFileFailure(t *testing.T) {
 		},
 	}
 
-	err := uploadFile(fs, client, "/consumefolder/test.pdf")
-	if err == nil {
+	p := newTestPipeline(t, fs, client)
+	outcome := p.uploadFile("/consumefolder/test.pdf")
+	if outcome.err == nil {
 		t.Errorf("expected error, got none")
 	}
+	if !outcome.retry {
+		t.Errorf("expected a 500 response to be marked retryable")
+	}
 
 	// Verify that the file still exists
 	if _, exists := fs.Files["/consumefolder/test.pdf"]; !exists {
@@ -126,14 +182,14 @@ func TestFileStability(t *testing.T) {
 		},
 	}
 
-	fileStabilityCheckInterval = 1 * time.Millisecond
-	fileStabilityCheckCount = 3
+	p := newTestPipeline(t, fs, &MockHTTPClient{})
+	ctx := context.Background()
 
-	go checkFileStability(fs)
-	fileStabilityConfirmation <- "/consumefolder/test.pdf"
+	go p.checkFileStability(ctx)
+	p.fileStabilityConfirmation <- "/consumefolder/test.pdf"
 
 	select {
-	case filePath := <-readyForUpload:
+	case filePath := <-p.readyForUpload:
 		if filePath != "/consumefolder/test.pdf" {
 			t.Errorf("expected /consumefolder/test.pdf, got %s", filePath)
 		}
@@ -150,19 +206,18 @@ func TestFileStabilityWithMultipleFiles(t *testing.T) {
 		},
 	}
 
-	// Simulate the stability check
-	fileStabilityCheckInterval = 1 * time.Millisecond
-	fileStabilityCheckCount = 3
+	p := newTestPipeline(t, fs, &MockHTTPClient{})
+	ctx := context.Background()
 
-	go checkFileStability(fs)
+	go p.checkFileStability(ctx)
 
 	// Send both files for stability confirmation
-	fileStabilityConfirmation <- "/consumefolder/test.pdf"
-	fileStabilityConfirmation <- "/consumefolder/test.txt"
+	p.fileStabilityConfirmation <- "/consumefolder/test.pdf"
+	p.fileStabilityConfirmation <- "/consumefolder/test.txt"
 
 	// Check the result in the readyForUpload channel
 	select {
-	case filePath := <-readyForUpload:
+	case filePath := <-p.readyForUpload:
 		if filePath != "/consumefolder/test.pdf" {
 			t.Errorf("expected /consumefolder/test.pdf, got %s", filePath)
 		}
@@ -172,7 +227,7 @@ func TestFileStabilityWithMultipleFiles(t *testing.T) {
 
 	// Ensure no other files are pushed to the channel
 	select {
-	case filePath := <-readyForUpload:
+	case filePath := <-p.readyForUpload:
 		t.Errorf("unexpected file pushed to channel: %s", filePath)
 	case <-time.After(10 * time.Millisecond):
 		// No additional files should be pushed
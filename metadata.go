@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// sidecarMetadata mirrors the subset of Paperless' post_document fields that
+// can be supplied out of band via a "<file>.json" or "<file>.yaml" sidecar
+// sitting next to the document.
+type sidecarMetadata struct {
+	Title               string                 `json:"title" yaml:"title"`
+	Created             string                 `json:"created" yaml:"created"`
+	Correspondent       string                 `json:"correspondent" yaml:"correspondent"`
+	DocumentType        string                 `json:"document_type" yaml:"document_type"`
+	Tags                []string               `json:"tags" yaml:"tags"`
+	ArchiveSerialNumber string                 `json:"archive_serial_number" yaml:"archive_serial_number"`
+	Owner               string                 `json:"owner" yaml:"owner"`
+	CustomFields        map[string]interface{} `json:"custom_fields" yaml:"custom_fields"`
+}
+
+// findSidecar returns the path of the metadata sidecar for filePath, trying
+// the .json extension before .yaml/.yml, and whether one was found.
+func findSidecar(fs FileSystem, filePath string) (string, bool) {
+	for _, suffix := range []string{".json", ".yaml", ".yml"} {
+		candidate := filePath + suffix
+		if _, err := fs.Stat(candidate); err == nil {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// loadSidecar reads and parses the sidecar at path, picking the decoder by
+// extension.
+func loadSidecar(fs FileSystem, path string) (*sidecarMetadata, error) {
+	reader, err := fs.Open(path)
+	if err != nil {
+		return nil, &sidecarIOError{err: fmt.Errorf("reading sidecar %s: %w", path, err)}
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, &sidecarIOError{err: fmt.Errorf("reading sidecar %s: %w", path, err)}
+	}
+
+	var meta sidecarMetadata
+	if strings.HasSuffix(path, ".json") {
+		err = json.Unmarshal(data, &meta)
+	} else {
+		err = yaml.Unmarshal(data, &meta)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("malformed sidecar %s: %w", path, err)
+	}
+	return &meta, nil
+}
+
+// sidecarIOError wraps a failure reading the sidecar file itself (a
+// permission error, the file vanishing mid-race, etc.), which is transient
+// or environmental rather than a sign the sidecar's content is malformed, so
+// callers should retry it like any other upload attempt instead of
+// quarantining the file.
+type sidecarIOError struct {
+	err error
+}
+
+func (e *sidecarIOError) Error() string { return e.err.Error() }
+func (e *sidecarIOError) Unwrap() error { return e.err }
+
+// metadataResolvers bundles the three name->ID resolvers a Pipeline needs to
+// turn sidecar field names into the IDs Paperless' post_document API wants.
+type metadataResolvers struct {
+	tags           *nameResolver
+	correspondents *nameResolver
+	documentTypes  *nameResolver
+}
+
+// applyFields writes the resolved Paperless fields from m onto writer. Tag,
+// correspondent and document type names are resolved to IDs via resolvers,
+// which cache lookups so a bulk import doesn't hammer the API.
+func (m *sidecarMetadata) applyFields(writer *multipart.Writer, resolvers *metadataResolvers) error {
+	if m.Created != "" {
+		if err := writer.WriteField("created", m.Created); err != nil {
+			return err
+		}
+	}
+
+	if m.Correspondent != "" {
+		id, err := resolvers.correspondents.resolve(m.Correspondent)
+		if err != nil {
+			return err
+		}
+		if err := writer.WriteField("correspondent", strconv.Itoa(id)); err != nil {
+			return err
+		}
+	}
+
+	if m.DocumentType != "" {
+		id, err := resolvers.documentTypes.resolve(m.DocumentType)
+		if err != nil {
+			return err
+		}
+		if err := writer.WriteField("document_type", strconv.Itoa(id)); err != nil {
+			return err
+		}
+	}
+
+	for _, tag := range m.Tags {
+		id, err := resolvers.tags.resolve(tag)
+		if err != nil {
+			return err
+		}
+		if err := writer.WriteField("tags", strconv.Itoa(id)); err != nil {
+			return err
+		}
+	}
+
+	if m.ArchiveSerialNumber != "" {
+		if err := writer.WriteField("archive_serial_number", m.ArchiveSerialNumber); err != nil {
+			return err
+		}
+	}
+
+	if m.Owner != "" {
+		if err := writer.WriteField("owner", m.Owner); err != nil {
+			return err
+		}
+	}
+
+	if len(m.CustomFields) > 0 {
+		encoded, err := json.Marshal(m.CustomFields)
+		if err != nil {
+			return err
+		}
+		if err := writer.WriteField("custom_fields", string(encoded)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// nameResolver looks up Paperless object IDs (tags, correspondents, document
+// types) by name, caching results in memory for resolverCacheTTL so a bulk
+// import doesn't re-query the API for every file sharing the same tag.
+type nameResolver struct {
+	client   HTTPClient
+	baseURL  string
+	token    string
+	endpoint string // e.g. "tags", "correspondents", "document_types"
+
+	mu      sync.Mutex
+	entries map[string]resolverEntry
+}
+
+type resolverEntry struct {
+	id      int
+	expires time.Time
+}
+
+const resolverCacheTTL = 5 * time.Minute
+
+func newNameResolver(client HTTPClient, baseURL, token, endpoint string) *nameResolver {
+	return &nameResolver{client: client, baseURL: baseURL, token: token, endpoint: endpoint, entries: map[string]resolverEntry{}}
+}
+
+func (r *nameResolver) resolve(name string) (int, error) {
+	key := strings.ToLower(name)
+
+	r.mu.Lock()
+	entry, cached := r.entries[key]
+	r.mu.Unlock()
+	if cached && time.Now().Before(entry.expires) {
+		return entry.id, nil
+	}
+
+	id, err := r.lookup(name)
+	if err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	r.entries[key] = resolverEntry{id: id, expires: time.Now().Add(resolverCacheTTL)}
+	r.mu.Unlock()
+
+	return id, nil
+}
+
+func (r *nameResolver) lookup(name string) (int, error) {
+	u := strings.TrimSuffix(r.baseURL, "/") + "/api/" + r.endpoint + "/?name__iexact=" + url.QueryEscape(name)
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Token "+r.token)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return 0, &resolverError{retry: true, err: err}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		// fall through to decoding the page below
+	case resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode == http.StatusRequestTimeout,
+		resp.StatusCode >= 500:
+		return 0, &resolverError{
+			retry:      true,
+			retryAfter: parseRetryAfter(resp),
+			err:        fmt.Errorf("lookup %s=%q failed with status %d", r.endpoint, name, resp.StatusCode),
+		}
+	default:
+		return 0, fmt.Errorf("lookup %s=%q failed with status %d", r.endpoint, name, resp.StatusCode)
+	}
+
+	var page struct {
+		Results []struct {
+			ID int `json:"id"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return 0, err
+	}
+	if len(page.Results) == 0 {
+		return 0, fmt.Errorf("no %s found named %q", r.endpoint, name)
+	}
+
+	return page.Results[0].ID, nil
+}
+
+// resolverError distinguishes a transient lookup failure (network error, or
+// a 429/408/5xx from Paperless) from a fatal one (bad request, name not
+// found), so callers can retry the former instead of treating every
+// resolution failure as an unrecoverable malformed sidecar.
+type resolverError struct {
+	retry      bool
+	retryAfter time.Duration
+	err        error
+}
+
+func (e *resolverError) Error() string { return e.err.Error() }
+func (e *resolverError) Unwrap() error { return e.err }
+
+// newMetadataResolvers builds the three resolvers a Pipeline needs, each
+// scoped to that pipeline's Paperless instance and HTTP client.
+func newMetadataResolvers(client HTTPClient, baseURL, token string) *metadataResolvers {
+	return &metadataResolvers{
+		tags:           newNameResolver(client, baseURL, token, "tags"),
+		correspondents: newNameResolver(client, baseURL, token, "correspondents"),
+		documentTypes:  newNameResolver(client, baseURL, token, "document_types"),
+	}
+}
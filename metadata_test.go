@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFindSidecarPrefersJSON(t *testing.T) {
+	fs := &MockFileSystem{
+		Files: map[string]*MockFile{
+			"/consumefolder/test.pdf.json": {FileName: "test.pdf.json", Content: []byte("{}")},
+			"/consumefolder/test.pdf.yaml": {FileName: "test.pdf.yaml", Content: []byte("title: ignored")},
+		},
+	}
+
+	path, ok := findSidecar(fs, "/consumefolder/test.pdf")
+	if !ok {
+		t.Fatalf("expected a sidecar to be found")
+	}
+	if path != "/consumefolder/test.pdf.json" {
+		t.Errorf("expected the .json sidecar to win, got %s", path)
+	}
+}
+
+func TestLoadSidecarParsesJSON(t *testing.T) {
+	fs := &MockFileSystem{
+		Files: map[string]*MockFile{
+			"/consumefolder/test.pdf.json": {
+				FileName: "test.pdf.json",
+				Content:  []byte(`{"title": "Invoice", "tags": ["bills", "2026"]}`),
+			},
+		},
+	}
+
+	meta, err := loadSidecar(fs, "/consumefolder/test.pdf.json")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if meta.Title != "Invoice" {
+		t.Errorf("expected title Invoice, got %q", meta.Title)
+	}
+	if len(meta.Tags) != 2 || meta.Tags[0] != "bills" {
+		t.Errorf("expected tags [bills 2026], got %v", meta.Tags)
+	}
+}
+
+func TestLoadSidecarMalformedReturnsError(t *testing.T) {
+	fs := &MockFileSystem{
+		Files: map[string]*MockFile{
+			"/consumefolder/test.pdf.json": {FileName: "test.pdf.json", Content: []byte("{not json")},
+		},
+	}
+
+	if _, err := loadSidecar(fs, "/consumefolder/test.pdf.json"); err == nil {
+		t.Errorf("expected an error for malformed sidecar")
+	}
+}
+
+func TestLoadSidecarIOErrorIsNotMalformed(t *testing.T) {
+	fs := &MockFileSystem{Files: map[string]*MockFile{}}
+
+	_, err := loadSidecar(fs, "/consumefolder/missing.pdf.json")
+	var ioErr *sidecarIOError
+	if !errors.As(err, &ioErr) {
+		t.Errorf("expected a failure reading the sidecar itself to be a sidecarIOError, got %v", err)
+	}
+}
+
+func TestNameResolverCachesLookups(t *testing.T) {
+	calls := 0
+	client := &countingHTTPClient{
+		do: func(req *http.Request) (*http.Response, error) {
+			calls++
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(`{"results": [{"id": 7}]}`)),
+			}, nil
+		},
+	}
+
+	resolver := newNameResolver(client, "http://paperless.local", "token123", "tags")
+
+	for i := 0; i < 3; i++ {
+		id, err := resolver.resolve("Bills")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if id != 7 {
+			t.Errorf("expected id 7, got %d", id)
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("expected the lookup to be cached after the first call, got %d HTTP calls", calls)
+	}
+}
+
+func TestNameResolverDistinguishesRetryableFromFatalLookupFailures(t *testing.T) {
+	client := &countingHTTPClient{
+		do: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: io.NopCloser(strings.NewReader(""))}, nil
+		},
+	}
+	resolver := newNameResolver(client, "http://paperless.local", "token123", "tags")
+
+	_, err := resolver.resolve("Bills")
+	var rErr *resolverError
+	if !errors.As(err, &rErr) || !rErr.retry {
+		t.Errorf("expected a 503 to be reported as a retryable resolverError, got %v", err)
+	}
+
+	client.do = func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{"results": []}`))}, nil
+	}
+
+	_, err = resolver.resolve("Nonexistent")
+	if errors.As(err, &rErr) {
+		t.Errorf("expected a name that doesn't exist in Paperless to be a fatal error, got retryable %v", err)
+	}
+}
+
+// countingHTTPClient lets tests supply an arbitrary Do implementation,
+// unlike MockHTTPClient which always returns the same canned response.
+type countingHTTPClient struct {
+	do func(req *http.Request) (*http.Response, error)
+}
+
+func (c *countingHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	return c.do(req)
+}
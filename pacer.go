@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Pacer paces upload attempts with exponential backoff, jitter, and decay,
+// the same shape used by rclone's mailru backend: the delay grows on every
+// retryable failure and relaxes back towards minSleep on success, instead of
+// retrying at a fixed interval regardless of how Paperless is behaving.
+type Pacer struct {
+	mu        sync.Mutex
+	sleepTime time.Duration
+	minSleep  time.Duration
+	maxSleep  time.Duration
+	factor    float64
+	decay     float64
+	onChange  func(time.Duration) // optional, e.g. to update a Prometheus gauge
+}
+
+// NewPacer returns a Pacer starting at minSleep. onChange, if non-nil, is
+// called with the new sleep duration every time it changes, so a caller can
+// mirror it onto a metric without the Pacer knowing about Prometheus.
+func NewPacer(minSleep, maxSleep time.Duration, factor, decay float64, onChange func(time.Duration)) *Pacer {
+	return &Pacer{
+		sleepTime: minSleep,
+		minSleep:  minSleep,
+		maxSleep:  maxSleep,
+		factor:    factor,
+		decay:     decay,
+		onChange:  onChange,
+	}
+}
+
+func (p *Pacer) notify(d time.Duration) {
+	if p.onChange != nil {
+		p.onChange(d)
+	}
+}
+
+// jitter adjusts d by up to ±20% so that concurrent uploaders don't retry in
+// lockstep.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return d
+	}
+	spread := float64(d) * 0.2
+	return d + time.Duration(spread*(2*rand.Float64()-1))
+}
+
+// Sleep blocks for the current paced delay, with jitter applied.
+func (p *Pacer) Sleep() {
+	p.mu.Lock()
+	d := p.sleepTime
+	p.mu.Unlock()
+	time.Sleep(jitter(d))
+}
+
+// Backoff grows the sleep interval after a retryable failure, by factor, up
+// to maxSleep. If the server gave a Retry-After longer than that, honor it.
+func (p *Pacer) Backoff(retryAfter time.Duration) {
+	p.mu.Lock()
+	p.sleepTime = time.Duration(float64(p.sleepTime) * p.factor)
+	if p.sleepTime > p.maxSleep {
+		p.sleepTime = p.maxSleep
+	}
+	if retryAfter > p.sleepTime {
+		p.sleepTime = retryAfter
+	}
+	d := p.sleepTime
+	p.mu.Unlock()
+	p.notify(d)
+}
+
+// Decay relaxes the sleep interval towards minSleep after a success.
+func (p *Pacer) Decay() {
+	p.mu.Lock()
+	p.sleepTime = time.Duration(float64(p.sleepTime) / p.decay)
+	if p.sleepTime < p.minSleep {
+		p.sleepTime = p.minSleep
+	}
+	d := p.sleepTime
+	p.mu.Unlock()
+	p.notify(d)
+}
+
+// uploadOutcome classifies the result of a single upload attempt so the
+// caller knows whether to back off and retry, or to give up immediately.
+type uploadOutcome struct {
+	retry       bool
+	retryAfter  time.Duration
+	err         error
+	sidecarPath string // set if a metadata sidecar was found alongside the document
+}
+
+// classifyUploadResult inspects the error/response from an HTTP attempt and
+// decides whether it is worth retrying. Network errors, 408, 429, and 5xx are
+// retryable, and a Retry-After header on any retryable response is honored.
+// Any other 4xx is treated as fatal, since retrying it would never succeed.
+func classifyUploadResult(resp *http.Response, err error) uploadOutcome {
+	if err != nil {
+		return uploadOutcome{retry: true, err: err}
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return uploadOutcome{}
+	case resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode == http.StatusRequestTimeout,
+		resp.StatusCode >= 500:
+		return uploadOutcome{
+			retry:      true,
+			retryAfter: parseRetryAfter(resp),
+			err:        fmt.Errorf("upload failed with status %d", resp.StatusCode),
+		}
+	default:
+		return uploadOutcome{err: fmt.Errorf("upload failed with status %d", resp.StatusCode)}
+	}
+}
+
+// parseRetryAfter reads the Retry-After header, if present, as a number of
+// seconds. It ignores the HTTP-date form, which Paperless does not emit.
+func parseRetryAfter(resp *http.Response) time.Duration {
+	header := resp.Header.Get("Retry-After")
+	if header == "" {
+		return 0
+	}
+	seconds, err := strconv.Atoi(header)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
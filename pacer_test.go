@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClassifyUploadResultRetryable(t *testing.T) {
+	resp := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}}
+	outcome := classifyUploadResult(resp, nil)
+	if !outcome.retry {
+		t.Errorf("expected 503 to be retryable")
+	}
+}
+
+func TestClassifyUploadResultFatal(t *testing.T) {
+	resp := &http.Response{StatusCode: http.StatusBadRequest, Header: http.Header{}}
+	outcome := classifyUploadResult(resp, nil)
+	if outcome.retry {
+		t.Errorf("expected 400 to be fatal, not retryable")
+	}
+}
+
+func TestClassifyUploadResultHonorsRetryAfter(t *testing.T) {
+	header := http.Header{}
+	header.Set("Retry-After", "5")
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: header}
+	outcome := classifyUploadResult(resp, nil)
+	if outcome.retryAfter != 5*time.Second {
+		t.Errorf("expected retryAfter of 5s, got %v", outcome.retryAfter)
+	}
+}
+
+func TestPacerBackoffAndDecay(t *testing.T) {
+	p := NewPacer(10*time.Millisecond, 100*time.Millisecond, 2, 2, nil)
+
+	p.Backoff(0)
+	p.mu.Lock()
+	sleep := p.sleepTime
+	p.mu.Unlock()
+	if sleep != 20*time.Millisecond {
+		t.Errorf("expected sleep to double to 20ms, got %v", sleep)
+	}
+
+	p.Decay()
+	p.mu.Lock()
+	sleep = p.sleepTime
+	p.mu.Unlock()
+	if sleep != 10*time.Millisecond {
+		t.Errorf("expected sleep to decay back to 10ms, got %v", sleep)
+	}
+}
+
+func TestPacerNotifiesOnChange(t *testing.T) {
+	var observed time.Duration
+	p := NewPacer(10*time.Millisecond, 100*time.Millisecond, 2, 2, func(d time.Duration) { observed = d })
+
+	p.Backoff(0)
+	if observed != 20*time.Millisecond {
+		t.Errorf("expected onChange to observe 20ms, got %v", observed)
+	}
+}
@@ -0,0 +1,726 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Pipeline is one watch-directory-to-Paperless-instance worker: everything
+// that used to be package-level state (channels, metrics, the pacer, the
+// ledger) now lives here instead, so a single process can run several
+// instances side by side without them sharing any mutable state.
+type Pipeline struct {
+	name string
+	cfg  *Config
+	inst InstanceConfig
+
+	fs     FileSystem
+	client HTTPClient
+
+	readyForUpload            chan string
+	fileStabilityConfirmation chan string
+
+	pacer     *Pacer
+	ledger    *Ledger
+	resolvers *metadataResolvers
+
+	failedDir string
+
+	watcherState   *watcherHealth
+	paperlessCheck *paperlessReachability
+
+	// registry is private to this pipeline, so two pipelines (or two test
+	// runs building pipelines with the same name) never collide trying to
+	// register the same metric name in a shared registry. main.go gathers
+	// across every pipeline's registry to serve one combined /metrics.
+	registry *prometheus.Registry
+
+	healthChecksMu sync.Mutex
+	healthChecks   map[string]HealthCheck
+
+	successfulUploads      prometheus.Counter
+	failedUploads          prometheus.Counter
+	uploadRetries          prometheus.Counter
+	uploadAttemptDuration  prometheus.Histogram
+	pacerSleepSeconds      prometheus.Gauge
+	healthCheckStatus      *prometheus.GaugeVec
+	watcherEventAgeSeconds prometheus.Gauge
+	readyForUploadDepth    prometheus.Gauge
+	fileStabilityDepth     prometheus.Gauge
+	paperlessReachable     prometheus.Gauge
+
+	inFlight sync.WaitGroup
+
+	// uploadSemaphore bounds how many uploads stream concurrently, since each
+	// holds its file open for the duration of the request.
+	uploadSemaphore chan struct{}
+	inFlightUploads prometheus.Gauge
+}
+
+// NewPipeline builds a Pipeline for inst, registering its metrics under a
+// registerer that injects {instance, watch_path} constant labels so every
+// pipeline's metrics can share the same /metrics endpoint without colliding.
+func NewPipeline(inst InstanceConfig, cfg *Config, fs FileSystem, client HTTPClient) (*Pipeline, error) {
+	failedDir := filepath.Join(inst.WatchPath, "failed")
+	if err := os.MkdirAll(failedDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	stateDir := cfg.StateDir
+	if stateDir == "" {
+		stateDir = filepath.Join(inst.WatchPath, ".doc2paperless-state")
+	}
+	stateDir = filepath.Join(stateDir, inst.Name)
+
+	ledger, err := OpenLedger(filepath.Join(stateDir, "ledger.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+
+	registry := prometheus.NewRegistry()
+	registerer := prometheus.WrapRegistererWith(prometheus.Labels{
+		"instance":   inst.Name,
+		"watch_path": inst.WatchPath,
+	}, registry)
+
+	p := &Pipeline{
+		registry:                  registry,
+		name:                      inst.Name,
+		cfg:                       cfg,
+		inst:                      inst,
+		fs:                        fs,
+		client:                    client,
+		readyForUpload:            make(chan string),
+		fileStabilityConfirmation: make(chan string),
+		ledger:                    ledger,
+		resolvers:                 newMetadataResolvers(client, inst.PaperlessBaseURL, inst.PaperlessAuthToken),
+		failedDir:                 failedDir,
+		watcherState:              &watcherHealth{},
+		paperlessCheck:            newPaperlessReachability(client, inst.PaperlessBaseURL, inst.PaperlessAuthToken, paperlessReachabilityCacheTTL),
+		healthChecks:              map[string]HealthCheck{},
+		successfulUploads:         prometheus.NewCounter(prometheus.CounterOpts{Name: "successful_uploads", Help: "Number of successful uploads"}),
+		failedUploads:             prometheus.NewCounter(prometheus.CounterOpts{Name: "failed_uploads", Help: "Number of failed uploads"}),
+		uploadRetries:             prometheus.NewCounter(prometheus.CounterOpts{Name: "upload_retries", Help: "Number of upload retries"}),
+		uploadAttemptDuration:     prometheus.NewHistogram(prometheus.HistogramOpts{Name: "upload_attempt_duration_seconds", Help: "Duration of individual upload attempts, one observation per attempt"}),
+		pacerSleepSeconds:         prometheus.NewGauge(prometheus.GaugeOpts{Name: "pacer_sleep_seconds", Help: "Current pacer delay applied before the next upload attempt"}),
+		healthCheckStatus:         prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "health_check_status", Help: "1 if the named health check is currently passing, 0 otherwise"}, []string{"check"}),
+		watcherEventAgeSeconds:    prometheus.NewGauge(prometheus.GaugeOpts{Name: "watcher_event_age_seconds", Help: "Seconds since the fsnotify watcher last observed a file event"}),
+		readyForUploadDepth:       prometheus.NewGauge(prometheus.GaugeOpts{Name: "ready_for_upload_depth", Help: "Number of files buffered in the readyForUpload channel"}),
+		fileStabilityDepth:        prometheus.NewGauge(prometheus.GaugeOpts{Name: "file_stability_confirmation_depth", Help: "Number of files buffered in the fileStabilityConfirmation channel"}),
+		paperlessReachable:        prometheus.NewGauge(prometheus.GaugeOpts{Name: "paperless_reachable", Help: "1 if the last Paperless reachability probe succeeded, 0 otherwise"}),
+		inFlightUploads:           prometheus.NewGauge(prometheus.GaugeOpts{Name: "uploads_in_flight", Help: "Number of uploads currently streaming to Paperless, bounded by MAX_CONCURRENT_UPLOADS"}),
+		uploadSemaphore:           make(chan struct{}, maxConcurrentUploads(cfg.MaxConcurrentUploads)),
+	}
+
+	registerer.MustRegister(p.successfulUploads, p.failedUploads, p.uploadRetries, p.uploadAttemptDuration, p.pacerSleepSeconds,
+		p.healthCheckStatus, p.watcherEventAgeSeconds, p.readyForUploadDepth, p.fileStabilityDepth, p.paperlessReachable, p.inFlightUploads)
+
+	p.pacer = NewPacer(cfg.PacerMinSleep, cfg.PacerMaxSleep, cfg.PacerBackoffFactor, cfg.PacerDecay, func(d time.Duration) {
+		p.pacerSleepSeconds.Set(d.Seconds())
+	})
+
+	p.registerStandardHealthChecks()
+
+	return p, nil
+}
+
+// maxConcurrentUploads falls back to GOMAXPROCS when cfg.MaxConcurrentUploads
+// isn't set, so a Pipeline built with a Config that leaves it zero (as
+// newTestPipeline does) doesn't end up with a semaphore of capacity zero.
+func maxConcurrentUploads(configured int) int {
+	if configured > 0 {
+		return configured
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// RegisterHealthCheck adds a named check to the registry healthHandler
+// composes, rather than the handler hardcoding each subsystem inline.
+func (p *Pipeline) RegisterHealthCheck(name string, check HealthCheck) {
+	p.healthChecksMu.Lock()
+	defer p.healthChecksMu.Unlock()
+	p.healthChecks[name] = check
+}
+
+// registerStandardHealthChecks wires up the checks every pipeline exposes:
+// whether its watcher goroutine is alive and recently active, whether its
+// internal channels are backing up, and whether its Paperless instance is
+// reachable.
+func (p *Pipeline) registerStandardHealthChecks() {
+	p.RegisterHealthCheck("watcher_alive", func() (bool, string) {
+		alive, _ := p.watcherState.snapshot()
+		if !alive {
+			return false, "fsnotify watcher is not running"
+		}
+		return true, ""
+	})
+
+	p.RegisterHealthCheck("watcher_event_age", func() (bool, string) {
+		_, lastEvent := p.watcherState.snapshot()
+		if lastEvent.IsZero() {
+			p.watcherEventAgeSeconds.Set(0)
+			return true, "no events observed yet"
+		}
+		age := time.Since(lastEvent)
+		p.watcherEventAgeSeconds.Set(age.Seconds())
+		if age > watcherStaleAfter {
+			return false, fmt.Sprintf("no watcher events for %s", age.Round(time.Second))
+		}
+		return true, ""
+	})
+
+	p.RegisterHealthCheck("channel_backpressure", func() (bool, string) {
+		ready := len(p.readyForUpload)
+		stability := len(p.fileStabilityConfirmation)
+		p.readyForUploadDepth.Set(float64(ready))
+		p.fileStabilityDepth.Set(float64(stability))
+		detail := fmt.Sprintf("readyForUpload=%d fileStabilityConfirmation=%d", ready, stability)
+		if ready > channelBackpressureThreshold || stability > channelBackpressureThreshold {
+			return false, detail
+		}
+		return true, detail
+	})
+
+	p.RegisterHealthCheck("paperless", func() (bool, string) {
+		ok, kind, err := p.paperlessCheck.Check()
+		if ok {
+			p.paperlessReachable.Set(1)
+			return true, ""
+		}
+		p.paperlessReachable.Set(0)
+		return false, fmt.Sprintf("%s: %v", kind, err)
+	})
+}
+
+// runHealthChecks executes every registered check, updating the per-check
+// gauge as it goes, and reports whether every check passed alongside each
+// one's detail.
+func (p *Pipeline) runHealthChecks() (allHealthy bool, checks map[string]map[string]interface{}) {
+	p.healthChecksMu.Lock()
+	registered := make(map[string]HealthCheck, len(p.healthChecks))
+	for name, check := range p.healthChecks {
+		registered[name] = check
+	}
+	p.healthChecksMu.Unlock()
+
+	allHealthy = true
+	checks = make(map[string]map[string]interface{}, len(registered))
+	for name, check := range registered {
+		ok, detail := check()
+		checks[name] = map[string]interface{}{"healthy": ok, "detail": detail}
+		if ok {
+			p.healthCheckStatus.WithLabelValues(name).Set(1)
+		} else {
+			p.healthCheckStatus.WithLabelValues(name).Set(0)
+			allHealthy = false
+		}
+	}
+	return allHealthy, checks
+}
+
+// monitorHealth periodically re-runs every registered health check so the
+// Prometheus gauges stay current even when nothing is scraping
+// /health/health directly, until ctx is cancelled.
+func (p *Pipeline) monitorHealth(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.runHealthChecks()
+		}
+	}
+}
+
+// Run starts the pipeline's watcher, stability checker, and uploader, and
+// blocks until ctx is cancelled. On cancellation it waits for in-flight
+// uploads to finish, up to cfg.ShutdownDrainTimeout, before returning.
+func (p *Pipeline) Run(ctx context.Context) error {
+	if err := p.reconcileLedger(); err != nil {
+		log.Printf("[%s] could not reconcile ledger: %v", p.name, err)
+	}
+
+	go p.monitorHealth(ctx, healthMonitorInterval)
+	go p.watchFiles(ctx)
+	go p.checkFileStability(ctx)
+	go p.uploadFiles(ctx)
+
+	<-ctx.Done()
+	log.Printf("[%s] shutting down, draining in-flight uploads...", p.name)
+
+	drained := make(chan struct{})
+	go func() {
+		p.inFlight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		log.Printf("[%s] all in-flight uploads drained", p.name)
+	case <-time.After(p.cfg.ShutdownDrainTimeout):
+		log.Printf("[%s] drain timeout of %s exceeded, exiting with uploads still in flight", p.name, p.cfg.ShutdownDrainTimeout)
+	}
+
+	return p.ledger.Close()
+}
+
+func (p *Pipeline) watchFiles(ctx context.Context) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer watcher.Close()
+	defer p.watcherState.markDead()
+
+	if err := watcher.Add(p.inst.WatchPath); err != nil {
+		log.Fatal(err)
+	}
+	p.watcherState.markAlive()
+
+	// Check existing files at startup
+	files, err := p.fs.ReadDir(p.inst.WatchPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, file := range files {
+		if !file.IsDir() && p.isWhitelisted(file.Name()) {
+			select {
+			case <-ctx.Done():
+				return
+			case p.fileStabilityConfirmation <- filepath.Join(p.inst.WatchPath, file.Name()):
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&fsnotify.Create == fsnotify.Create && p.isWhitelisted(event.Name) {
+				log.Printf("[%s] Detected new file. Starting stability check for: %s", p.name, event.Name)
+				p.watcherState.markEvent()
+				select {
+				case <-ctx.Done():
+					return
+				case p.fileStabilityConfirmation <- event.Name:
+				}
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("[%s] error: %v", p.name, err)
+		}
+	}
+}
+
+func (p *Pipeline) checkFileStability(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case filePath := <-p.fileStabilityConfirmation:
+			if !p.isWhitelisted(filePath) {
+				continue
+			}
+			go p.watchStability(ctx, filePath)
+		}
+	}
+}
+
+func (p *Pipeline) watchStability(ctx context.Context, filePath string) {
+	var lastSize int64
+	consecutiveStableCount := 0
+
+	for {
+		if p.cfg.Verbose {
+			log.Printf("[%s] Checking stability for %s Consecutive readings with same size: %d/%d", p.name, filePath, consecutiveStableCount, p.cfg.FileStabilityCheckCount)
+		}
+
+		fileInfo, err := p.fs.Stat(filePath)
+		if err != nil {
+			log.Printf("[%s] error: %v", p.name, err)
+			return
+		}
+
+		currentSize := fileInfo.Size()
+		if currentSize == lastSize {
+			consecutiveStableCount++
+			if consecutiveStableCount >= p.cfg.FileStabilityCheckCount {
+				if p.cfg.Verbose {
+					log.Printf("[%s] Checking stability for %s: Consecutive readings with same size: %d/%d -> OK, ready for Upload.", p.name, filePath, consecutiveStableCount, p.cfg.FileStabilityCheckCount)
+				}
+				select {
+				case <-ctx.Done():
+				case p.readyForUpload <- filePath:
+				}
+				return
+			}
+		} else {
+			consecutiveStableCount = 0
+		}
+
+		lastSize = currentSize
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.cfg.FileStabilityCheckInterval):
+		}
+	}
+}
+
+func (p *Pipeline) uploadFiles(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case filePath := <-p.readyForUpload:
+			p.inFlight.Add(1)
+			go func(filePath string) {
+				defer p.inFlight.Done()
+
+				select {
+				case p.uploadSemaphore <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+				p.inFlightUploads.Inc()
+				defer func() {
+					p.inFlightUploads.Dec()
+					<-p.uploadSemaphore
+				}()
+
+				p.retryUpload(filePath)
+			}(filePath)
+		}
+	}
+}
+
+func (p *Pipeline) retryUpload(filePath string) {
+	attempts := 0
+	for {
+		attempts++
+		outcome := p.uploadFile(filePath)
+		if outcome.err == nil {
+			p.successfulUploads.Inc()
+			log.Printf("[%s] Successfully uploaded: %s", p.name, filePath)
+			p.fs.Remove(filePath)
+			if outcome.sidecarPath != "" {
+				p.fs.Remove(outcome.sidecarPath)
+			}
+			p.pacer.Decay()
+			return
+		}
+
+		if !outcome.retry || attempts >= p.cfg.MaxRetries {
+			p.failedUploads.Inc()
+			log.Printf("[%s] Giving up on %s after %d attempt(s): %v", p.name, filePath, attempts, outcome.err)
+			p.quarantineFile(filePath)
+			if outcome.sidecarPath != "" {
+				p.quarantineFile(outcome.sidecarPath)
+			}
+			return
+		}
+
+		p.uploadRetries.Inc()
+		log.Printf("[%s] Failed to upload: %s, retrying (%d/%d): %v", p.name, filePath, attempts, p.cfg.MaxRetries, outcome.err)
+		p.pacer.Backoff(outcome.retryAfter)
+		p.pacer.Sleep()
+	}
+}
+
+// quarantineFile moves a file that exhausted its retries or failed with a
+// non-retryable error (a document or its sidecar) into failedDir so it stops
+// being retried, rather than looping on it forever.
+func (p *Pipeline) quarantineFile(filePath string) {
+	dest := filepath.Join(p.failedDir, filepath.Base(filePath))
+	if err := p.fs.Rename(filePath, dest); err != nil {
+		log.Printf("[%s] Failed to move %s to %s: %v", p.name, filePath, dest, err)
+	}
+}
+
+// streamMultipartBody writes head, then streams file into w, then writes
+// tail, stopping at the first error so the caller can abort the pipe with
+// it rather than let the reader side hang.
+func streamMultipartBody(w io.Writer, head []byte, file io.Reader, tail []byte) error {
+	if _, err := w.Write(head); err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, file); err != nil {
+		return err
+	}
+	_, err := w.Write(tail)
+	return err
+}
+
+func (p *Pipeline) uploadFile(filePath string) uploadOutcome {
+	hash, err := hashFile(p.fs, filePath)
+	if err != nil {
+		return uploadOutcome{err: err}
+	}
+
+	sidecar, hasSidecar := findSidecar(p.fs, filePath)
+
+	if record, ok := p.ledger.Lookup(hash); ok {
+		if record.Status == ledgerStatusSuccess {
+			log.Printf("[%s] Skipping %s: content already uploaded as task %s", p.name, filePath, record.TaskID)
+			return uploadOutcome{sidecarPath: sidecar}
+		}
+		if record.TaskID != "" {
+			log.Printf("[%s] Resuming %s: already posted as task %s, resolving its status instead of re-uploading", p.name, filePath, record.TaskID)
+			return p.resolveTask(filePath, hash, record.TaskID, sidecar)
+		}
+	}
+
+	p.ledger.Record(ledgerEntry{Hash: hash, Path: filePath, UploadedAt: time.Now(), Status: ledgerStatusPending})
+
+	var meta *sidecarMetadata
+	if hasSidecar {
+		meta, err = loadSidecar(p.fs, sidecar)
+		if err != nil {
+			var ioErr *sidecarIOError
+			if errors.As(err, &ioErr) {
+				return uploadOutcome{retry: true, err: err, sidecarPath: sidecar}
+			}
+			return uploadOutcome{err: err, sidecarPath: sidecar}
+		}
+	}
+
+	// Build the envelope (every field plus the document part's header) in
+	// memory first, then stream the file itself straight into the request
+	// body, so a large document is never buffered into RAM whole.
+	head := &bytes.Buffer{}
+	writer := multipart.NewWriter(head)
+
+	title := filepath.Base(filePath)
+	if meta != nil && meta.Title != "" {
+		title = meta.Title
+	}
+	if err := writer.WriteField("title", title); err != nil {
+		return uploadOutcome{err: err, sidecarPath: sidecar}
+	}
+
+	if meta != nil {
+		if err := meta.applyFields(writer, p.resolvers); err != nil {
+			var rErr *resolverError
+			if errors.As(err, &rErr) && rErr.retry {
+				return uploadOutcome{retry: true, retryAfter: rErr.retryAfter, err: fmt.Errorf("resolving sidecar %s fields: %w", sidecar, err), sidecarPath: sidecar}
+			}
+			return uploadOutcome{err: fmt.Errorf("malformed sidecar %s: %w", sidecar, err), sidecarPath: sidecar}
+		}
+	}
+
+	if _, err := writer.CreateFormFile("document", filepath.Base(filePath)); err != nil {
+		return uploadOutcome{err: err, sidecarPath: sidecar}
+	}
+	tail := fmt.Sprintf("\r\n--%s--\r\n", writer.Boundary())
+
+	fileInfo, err := p.fs.Stat(filePath)
+	if err != nil {
+		return uploadOutcome{err: err, sidecarPath: sidecar}
+	}
+
+	fileReader, err := p.fs.Open(filePath)
+	if err != nil {
+		return uploadOutcome{err: err, sidecarPath: sidecar}
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		err := streamMultipartBody(pw, head.Bytes(), fileReader, []byte(tail))
+		fileReader.Close()
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.Close()
+	}()
+
+	uploadURL := strings.TrimSuffix(p.inst.PaperlessBaseURL, "/") + "/api/documents/post_document/"
+	req, err := http.NewRequest("POST", uploadURL, pr)
+	if err != nil {
+		return uploadOutcome{err: err, sidecarPath: sidecar}
+	}
+	req.ContentLength = int64(head.Len()) + fileInfo.Size() + int64(len(tail))
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Token "+p.inst.PaperlessAuthToken)
+
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	p.uploadAttemptDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		return uploadOutcome{retry: true, err: err, sidecarPath: sidecar}
+	}
+	defer resp.Body.Close()
+
+	responseBody, _ := io.ReadAll(resp.Body)
+	outcome := classifyUploadResult(resp, nil)
+	outcome.sidecarPath = sidecar
+	if outcome.err != nil {
+		if resp.StatusCode != http.StatusOK {
+			log.Printf("[%s] Failed to upload document: Status %d, Response: %s", p.name, resp.StatusCode, string(responseBody))
+		}
+		p.ledger.Record(ledgerEntry{Hash: hash, Path: filePath, UploadedAt: time.Now(), Status: ledgerStatusFailed})
+		return outcome
+	}
+
+	taskID := strings.Trim(strings.TrimSpace(string(responseBody)), `"`)
+	return p.resolveTask(filePath, hash, taskID, sidecar)
+}
+
+// resolveTask polls a task that has already been posted to Paperless through
+// to completion. It's used both right after a fresh POST and to resume a
+// retry whose previous attempt posted the document successfully but failed
+// while polling: re-running the whole upload in that case would risk
+// creating a second, duplicate document server-side, so the retry must
+// resolve the existing taskID instead of posting again.
+func (p *Pipeline) resolveTask(filePath, hash, taskID, sidecar string) uploadOutcome {
+	status, err := p.pollTaskStatus(taskID)
+	if err != nil {
+		log.Printf("[%s] Could not resolve Paperless task %s for %s: %v", p.name, taskID, filePath, err)
+		p.ledger.Record(ledgerEntry{Hash: hash, Path: filePath, UploadedAt: time.Now(), TaskID: taskID, Status: ledgerStatusFailed})
+		return uploadOutcome{retry: true, err: fmt.Errorf("resolving paperless task %s status: %w", taskID, err), sidecarPath: sidecar}
+	}
+
+	ledgerStatus := ledgerStatusSuccess
+	outcome := uploadOutcome{sidecarPath: sidecar}
+	if strings.EqualFold(status, "FAILURE") {
+		ledgerStatus = ledgerStatusFailed
+		outcome = uploadOutcome{err: fmt.Errorf("paperless task %s reported failure", taskID), sidecarPath: sidecar}
+	}
+	p.ledger.Record(ledgerEntry{Hash: hash, Path: filePath, UploadedAt: time.Now(), TaskID: taskID, Status: ledgerStatus})
+
+	return outcome
+}
+
+func (p *Pipeline) isWhitelisted(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	whitelistedExtensions := strings.Split(strings.ToLower(p.cfg.Whitelist), ",")
+	for _, pattern := range whitelistedExtensions {
+		if matched, _ := filepath.Match(pattern, ext); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// reconcileLedger walks the watch path at startup and removes files whose
+// content already has a successful ledger entry, so they aren't re-uploaded.
+// It deliberately leaves files tied to a still-pending entry (the process
+// crashed before the upload was confirmed) untouched on disk: Run calls this
+// before watchFiles starts, and watchFiles' own startup scan picks up every
+// remaining file exactly once, so re-queuing them here too would race it into
+// double-uploading the same document.
+func (p *Pipeline) reconcileLedger() error {
+	entries, err := p.fs.ReadDir(p.inst.WatchPath)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !p.isWhitelisted(entry.Name()) {
+			continue
+		}
+
+		path := filepath.Join(p.inst.WatchPath, entry.Name())
+		hash, err := hashFile(p.fs, path)
+		if err != nil {
+			log.Printf("[%s] Could not hash %s during reconciliation: %v", p.name, path, err)
+			continue
+		}
+
+		record, ok := p.ledger.Lookup(hash)
+		if !ok {
+			continue
+		}
+
+		switch record.Status {
+		case ledgerStatusSuccess:
+			log.Printf("[%s] Removing %s: content already uploaded as task %s", p.name, path, record.TaskID)
+			p.fs.Remove(path)
+			if sidecar, ok := findSidecar(p.fs, path); ok {
+				p.fs.Remove(sidecar)
+			}
+		case ledgerStatusPending:
+			log.Printf("[%s] Found %s with an upload interrupted by a previous crash; the startup file scan will re-queue it", p.name, path)
+		}
+	}
+
+	return nil
+}
+
+// pollTaskStatus polls Paperless' task endpoint until taskID reaches a
+// terminal state (SUCCESS or FAILURE) or taskPollTimeout elapses.
+func (p *Pipeline) pollTaskStatus(taskID string) (string, error) {
+	deadline := time.Now().Add(taskPollTimeout)
+	for {
+		status, err := p.fetchTaskStatus(taskID)
+		if err != nil {
+			return "", err
+		}
+
+		switch strings.ToUpper(status) {
+		case "SUCCESS", "FAILURE":
+			return status, nil
+		}
+
+		if time.Now().After(deadline) {
+			return status, fmt.Errorf("task %s did not resolve within %s", taskID, taskPollTimeout)
+		}
+		time.Sleep(taskPollInterval)
+	}
+}
+
+func (p *Pipeline) fetchTaskStatus(taskID string) (string, error) {
+	u := strings.TrimSuffix(p.inst.PaperlessBaseURL, "/") + "/api/tasks/?task_id=" + url.QueryEscape(taskID)
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Token "+p.inst.PaperlessAuthToken)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("task lookup for %s failed with status %d", taskID, resp.StatusCode)
+	}
+
+	var tasks []struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tasks); err != nil {
+		return "", err
+	}
+	if len(tasks) == 0 {
+		return "", fmt.Errorf("no task found for id %s", taskID)
+	}
+
+	return tasks[0].Status, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamMultipartBodyWritesHeadFileTail(t *testing.T) {
+	var out bytes.Buffer
+	err := streamMultipartBody(&out, []byte("HEAD|"), strings.NewReader("FILE"), []byte("|TAIL"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if out.String() != "HEAD|FILE|TAIL" {
+		t.Errorf("expected head, file, and tail to be written in order, got %q", out.String())
+	}
+}
+
+func TestStreamMultipartBodyPropagatesCopyError(t *testing.T) {
+	boom := errors.New("read failed")
+	err := streamMultipartBody(&bytes.Buffer{}, []byte("HEAD|"), iotestErrReader{err: boom}, []byte("|TAIL"))
+	if !errors.Is(err, boom) {
+		t.Errorf("expected the file read error to propagate, got %v", err)
+	}
+}
+
+// iotestErrReader always fails, simulating a document that errors partway
+// through being read.
+type iotestErrReader struct{ err error }
+
+func (r iotestErrReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestMaxConcurrentUploadsDefaultsToGOMAXPROCS(t *testing.T) {
+	if got := maxConcurrentUploads(4); got != 4 {
+		t.Errorf("expected an explicit positive limit to be kept as-is, got %d", got)
+	}
+	if got := maxConcurrentUploads(0); got != runtime.GOMAXPROCS(0) {
+		t.Errorf("expected a zero-value limit to fall back to GOMAXPROCS (%d), got %d", runtime.GOMAXPROCS(0), got)
+	}
+}
+
+func TestUploadFileSkipsDuplicateAndReportsSidecarForRemoval(t *testing.T) {
+	fs := &MockFileSystem{
+		Files: map[string]*MockFile{
+			"/consumefolder/dup.pdf":      {FileName: "dup.pdf", Content: []byte("already uploaded")},
+			"/consumefolder/dup.pdf.json": {FileName: "dup.pdf.json", Content: []byte("{}")},
+		},
+	}
+
+	p := newTestPipeline(t, fs, &MockHTTPClient{})
+	hash, err := hashFile(fs, "/consumefolder/dup.pdf")
+	if err != nil {
+		t.Fatalf("expected no error hashing file, got %v", err)
+	}
+	p.ledger.Record(ledgerEntry{Hash: hash, Path: "/consumefolder/dup.pdf", UploadedAt: time.Now(), Status: ledgerStatusSuccess})
+
+	outcome := p.uploadFile("/consumefolder/dup.pdf")
+	if outcome.err != nil {
+		t.Fatalf("expected no error, got %v", outcome.err)
+	}
+	if outcome.sidecarPath != "/consumefolder/dup.pdf.json" {
+		t.Errorf("expected the duplicate's sidecar to be reported so the caller removes it alongside the document, got %q", outcome.sidecarPath)
+	}
+}
+
+func TestUploadFileRetriesTaskPollWithoutReuploading(t *testing.T) {
+	fs := &MockFileSystem{
+		Files: map[string]*MockFile{
+			"/consumefolder/test.pdf": {FileName: "test.pdf", Content: []byte("test content"), FileSize: 12},
+		},
+	}
+
+	posts := 0
+	pollFailures := 1
+	client := &countingHTTPClient{
+		do: func(req *http.Request) (*http.Response, error) {
+			if req.Method == http.MethodGet {
+				if pollFailures > 0 {
+					pollFailures--
+					return nil, errors.New("transient poll failure")
+				}
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`[{"status":"SUCCESS"}]`))}, nil
+			}
+			posts++
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`"42"`))}, nil
+		},
+	}
+
+	p := newTestPipeline(t, fs, client)
+
+	outcome := p.uploadFile("/consumefolder/test.pdf")
+	if !outcome.retry {
+		t.Fatalf("expected a failed task poll to be reported as retryable, got %+v", outcome)
+	}
+
+	outcome = p.uploadFile("/consumefolder/test.pdf")
+	if outcome.err != nil {
+		t.Fatalf("expected the retry to resolve the already-posted task successfully, got %v", outcome.err)
+	}
+
+	if posts != 1 {
+		t.Errorf("expected the document to be posted exactly once, retrying the poll rather than re-uploading, got %d posts", posts)
+	}
+}
+
+func TestUploadFileSetsAccurateContentLength(t *testing.T) {
+	fs := &MockFileSystem{
+		Files: map[string]*MockFile{
+			"/consumefolder/test.pdf": {FileName: "test.pdf", Content: []byte("streamed content"), FileSize: 16},
+		},
+	}
+
+	var observedLength int64
+	var observedBodyLen int
+	client := &countingHTTPClient{
+		do: func(req *http.Request) (*http.Response, error) {
+			if req.Method == http.MethodGet {
+				// The task-status poll that follows a successful upload;
+				// resolve it immediately so uploadFile doesn't block on it.
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`[{"status":"SUCCESS"}]`))}, nil
+			}
+
+			observedLength = req.ContentLength
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				t.Fatalf("expected no error reading streamed body, got %v", err)
+			}
+			observedBodyLen = len(body)
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`"42"`))}, nil
+		},
+	}
+
+	p := newTestPipeline(t, fs, client)
+	outcome := p.uploadFile("/consumefolder/test.pdf")
+	if outcome.err != nil {
+		t.Fatalf("expected no error, got %v", outcome.err)
+	}
+
+	if int64(observedBodyLen) != observedLength {
+		t.Errorf("expected the streamed body length (%d) to match the precomputed Content-Length (%d)", observedBodyLen, observedLength)
+	}
+}